@@ -0,0 +1,189 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package accesslog provides ready-to-use ihandler.PostMetricsFunc implementations that log
+// every request in the Apache Common/Combined Log Format, or as JSON, using the Metrics
+// captured by ihandler.
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluelamar/http-interceptor-go/ihandler"
+)
+
+// Logger formats access-log entries. Its zero value is ready to use and never honors
+// X-Forwarded-For / X-Real-IP; set TrustedProxies to opt into that, à la gorilla/handlers'
+// proxy_headers. Set RequestIDHeader to the header populated by WithRequestID to have it
+// included in every log line.
+type Logger struct {
+	// TrustedProxies lists client IPs and/or CIDRs allowed to set X-Forwarded-For /
+	// X-Real-IP. When empty, those headers are ignored and r.RemoteAddr is always used.
+	TrustedProxies []string
+
+	// RequestIDHeader, when set, names the header WithRequestID stamps so it can be pulled
+	// back out and included in the log line.
+	RequestIDHeader string
+}
+
+// Common returns a PostMetricsFunc that writes one Apache Common Log Format line per request
+// to w, using the default Logger (no TrustedProxies, no request id).
+func Common(w io.Writer) ihandler.PostMetricsFunc {
+	return Logger{}.Common(w)
+}
+
+// Combined returns a PostMetricsFunc that writes one Apache Combined Log Format line per
+// request to w, using the default Logger (no TrustedProxies, no request id).
+func Combined(w io.Writer) ihandler.PostMetricsFunc {
+	return Logger{}.Combined(w)
+}
+
+// JSON returns a PostMetricsFunc that writes one JSON object per request to w, using the
+// default Logger (no TrustedProxies, no request id). fields names additional request headers
+// to include in each entry.
+func JSON(w io.Writer, fields ...string) ihandler.PostMetricsFunc {
+	return Logger{}.JSON(w, fields...)
+}
+
+// Common returns a PostMetricsFunc that writes one Apache Common Log Format line per request
+// to w: `host - - [time] "method uri proto" status bytes`.
+func (l Logger) Common(w io.Writer) ihandler.PostMetricsFunc {
+	return func(rw ihandler.InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *ihandler.Metrics) {
+		fmt.Fprint(w, l.commonLine(rw, r, m))
+	}
+}
+
+// Combined returns a PostMetricsFunc that writes one Apache Combined Log Format line per
+// request to w: the Common Log Format line plus the referrer and user-agent.
+func (l Logger) Combined(w io.Writer) ihandler.PostMetricsFunc {
+	return func(rw ihandler.InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *ihandler.Metrics) {
+		line := strings.TrimSuffix(strings.TrimSuffix(l.commonLine(rw, r, m), "\n"), l.requestIDSuffix(rw, r))
+		fmt.Fprintf(w, "%s \"%s\" \"%s\"%s\n", line, r.Referer(), r.UserAgent(), l.requestIDSuffix(rw, r))
+	}
+}
+
+// JSON returns a PostMetricsFunc that writes one JSON object per request to w. fields names
+// additional request headers to include in each entry, keyed by their header name.
+func (l Logger) JSON(w io.Writer, fields ...string) ihandler.PostMetricsFunc {
+	return func(rw ihandler.InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *ihandler.Metrics) {
+		entry := map[string]any{
+			"time":        time.Now().Format(time.RFC3339),
+			"remote_addr": l.remoteAddr(r),
+			"method":      r.Method,
+			"uri":         r.URL.RequestURI(),
+			"proto":       r.Proto,
+			"status":      m.Code,
+			"bytes":       m.Written,
+			"duration_ms": float64(m.Duration.Microseconds()) / 1000.0,
+			"referer":     r.Referer(),
+			"user_agent":  r.UserAgent(),
+		}
+
+		if id := l.requestID(rw, r); id != "" {
+			entry["request_id"] = id
+		}
+
+		for _, field := range fields {
+			entry[field] = r.Header.Get(field)
+		}
+
+		if err := json.NewEncoder(w).Encode(entry); err != nil {
+			fmt.Fprintf(w, `{"accesslog_error":%q}`+"\n", err.Error())
+		}
+	}
+}
+
+func (l Logger) commonLine(rw ihandler.InterceptResponseWriterI, r *http.Request, m *ihandler.Metrics) string {
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %d%s\n",
+		l.remoteAddr(r),
+		time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, r.URL.RequestURI(), r.Proto,
+		m.Code, m.Written,
+		l.requestIDSuffix(rw, r),
+	)
+}
+
+func (l Logger) requestIDSuffix(rw ihandler.InterceptResponseWriterI, r *http.Request) string {
+	if id := l.requestID(rw, r); id != "" {
+		return " rid=" + id
+	}
+
+	return ""
+}
+
+func (l Logger) requestID(rw ihandler.InterceptResponseWriterI, r *http.Request) string {
+	if l.RequestIDHeader == "" {
+		return ""
+	}
+
+	if id := rw.Header().Get(l.RequestIDHeader); id != "" {
+		return id
+	}
+
+	return r.Header.Get(l.RequestIDHeader)
+}
+
+// remoteAddr returns r's client address, honoring X-Forwarded-For / X-Real-IP only when
+// r.RemoteAddr matches one of l.TrustedProxies.
+func (l Logger) remoteAddr(r *http.Request) string {
+	host := r.RemoteAddr
+	if h, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		host = h
+	}
+
+	if !l.isTrustedProxy(host) {
+		return host
+	}
+
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		client, _, _ := strings.Cut(fwd, ",")
+		return strings.TrimSpace(client)
+	}
+
+	if real := r.Header.Get("X-Real-IP"); real != "" {
+		return real
+	}
+
+	return host
+}
+
+func (l Logger) isTrustedProxy(host string) bool {
+	if len(l.TrustedProxies) == 0 {
+		return false
+	}
+
+	ip := net.ParseIP(host)
+
+	for _, trusted := range l.TrustedProxies {
+		if strings.Contains(trusted, "/") {
+			if _, cidr, err := net.ParseCIDR(trusted); err == nil && ip != nil && cidr.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		if trusted == host {
+			return true
+		}
+	}
+
+	return false
+}
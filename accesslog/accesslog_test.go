@@ -0,0 +1,91 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bluelamar/abstract-logger-go/alogger"
+	"github.com/bluelamar/http-interceptor-go/ihandler"
+)
+
+func okHandler(w ihandler.InterceptResponseWriterI, r *http.Request) {
+	w.Write([]byte("hello"))
+}
+
+func TestCommonLogsRequest(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	l := alogger.New(nil, true)
+	ih := ihandler.New(okHandler, nil, nil, l).WithPostMetrics(Common(&buf))
+
+	ts := httptest.NewServer(ih)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	line := buf.String()
+
+	if !strings.Contains(line, `"GET / HTTP/1.1" 200 5`) {
+		t.Fatalf(`Expected common log line to contain request line and status/bytes, got: %s`, line)
+	}
+}
+
+func TestJSONLogsRequestID(t *testing.T) {
+
+	var buf bytes.Buffer
+
+	l := alogger.New(nil, true)
+	logger := Logger{RequestIDHeader: "X-Request-Id"}
+	ih := ihandler.New(okHandler, nil, nil, l).
+		WithPre(WithRequestID("X-Request-Id")).
+		WithPostMetrics(logger.JSON(&buf))
+
+	ts := httptest.NewServer(ih)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf(`Failed to unmarshal JSON log entry: %v (raw: %s)`, err, buf.String())
+	}
+
+	if entry["status"].(float64) != 200 {
+		t.Fatalf(`Expected status(200) but got(%v)`, entry["status"])
+	}
+
+	if _, ok := entry["request_id"]; !ok {
+		t.Fatalf(`Expected request_id to be present in JSON log entry: %v`, entry)
+	}
+
+	if res.Header.Get("X-Request-Id") == "" {
+		t.Fatal(`Expected WithRequestID to stamp X-Request-Id on the response`)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package accesslog
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net/http"
+
+	"github.com/bluelamar/http-interceptor-go/ihandler"
+)
+
+// WithRequestID returns an ihandler.AuthorizerFunc, for registration via WithPre, that ensures
+// the named header carries a request id: it passes through whatever the client (or an upstream
+// proxy) already supplied, or generates a UUID v4 when absent. Either way it stamps the id onto
+// the response via AddHeader, so operators can correlate auth failures with the access-log entry
+// for the same request. Set Logger.RequestIDHeader to the same header name to have it included
+// in the log line too.
+func WithRequestID(header string) ihandler.AuthorizerFunc {
+	return func(w ihandler.InterceptResponseWriterI, r *http.Request) (error, int, string) {
+		id := r.Header.Get(header)
+		if id == "" {
+			id = newRequestID()
+			r.Header.Set(header, id)
+		}
+
+		w.AddHeader(header, id)
+
+		return nil, 0, ""
+	}
+}
+
+// newRequestID generates an RFC 4122 version 4 UUID.
+func newRequestID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -15,17 +15,135 @@
 package ihandler
 
 import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bluelamar/abstract-logger-go/alogger"
+	"github.com/bluelamar/http-interceptor-go/session"
 )
 
-// InterceptResponseWriterI is a plugin replacement for the RespoonseWriter interface.
-// It has additional functionality for http handlers to add cookies and headers to the response.
-type InterceptResponseWriterI interface {
-	// HandleFunc used as the handler in the http.HandleFunc call.
-	HandleFunc(w http.ResponseWriter, r *http.Request)
+// Builder configures a route's handling: pre-handlers, the user handler, post-handlers, and
+// optional features such as panic recovery, compression, signed cookies, and sessions. New
+// returns a Builder, and the Builder itself is an http.Handler: each incoming request allocates
+// a fresh, request-scoped InterceptResponseWriterI internally, so a single Builder is safe to
+// register once and reused across many concurrent requests.
+//
+// Migrating from the old shared-state API: New used to return an InterceptResponseWriterI that
+// you wrapped in your own http.Handler shim and registered directly with http.HandleFunc. Now
+// New returns a Builder; register it directly (it implements http.Handler) or via Mount, and
+// drop the shim. The With* configuration calls are unchanged other than now being called on the
+// Builder instead of the per-request writer.
+type Builder interface {
+	http.Handler
+
+	// WithPre allows adding pre-handler function to list of pre-handler functions.
+	// Pre-handlers are called before the user provided handler function is called.
+	WithPre(af AuthorizerFunc) Builder
+
+	// WithPost allows adding post-handler function to list of post-handler functions.
+	// Post-handlers are called after the user provided handler function is called.
+	WithPost(PostResponseFunc) Builder
+
+	// WithPostMetrics allows adding a post-handler function that additionally receives the
+	// Metrics captured for this response, so it doesn't have to recompute status/byte counts
+	// by walking respBytes itself. Coexists with WithPost; both kinds run.
+	WithPostMetrics(PostMetricsFunc) Builder
+
+	// WithRecover opts this handler into panic recovery across the authorizer, user-handler,
+	// and post-handler phases. A nil onPanic installs DefaultRecoverFunc.
+	// See RecoverFunc for details of what is expected of onPanic.
+	WithRecover(onPanic RecoverFunc) Builder
+
+	// WithPrintStack controls whether a recovered panic's stack trace is logged. The stack is
+	// never included in the response written by DefaultRecoverFunc. Defaults to false. Has no
+	// effect unless WithRecover has also been called.
+	WithPrintStack(print bool) Builder
+
+	// WithCompression opts this handler into negotiating a Content-Encoding with the request's
+	// Accept-Encoding header and compressing the buffered response once, after the WithPost
+	// post-handlers have run and before the WithPostMetrics handlers run and the response is
+	// flushed. See CompressionOptions.
+	WithCompression(opts CompressionOptions) Builder
+
+	// WithCookieCodec configures one or more CookieCodec for use by SetSignedCookie and
+	// GetSignedCookie. Pass the current signing/encryption key first, followed by any
+	// previously-rotated-out keys so GetSignedCookie can still decode older cookies.
+	WithCookieCodec(codecs ...*CookieCodec) Builder
+
+	// WithSessionStore configures the session.Store used by InterceptResponseWriterI.Session.
+	WithSessionStore(store session.Store) Builder
+
+	// Mount registers this Builder as the handler for pattern on mux. Equivalent to
+	// mux.Handle(pattern, b), kept as a convenience so callers don't need their own shim.
+	Mount(mux *http.ServeMux, pattern string)
+}
+
+// Chain composes builders into a single Builder: pre-handlers run in builders' declared order
+// (the first builder's run outermost), post-handlers run in the reverse order (the last
+// builder's run first, bubbling outward), and the last non-nil UserHandlerFunc among builders
+// becomes the chain's handler. This matches the composition convention used by alice and
+// negroni, letting earlier builders act as pure middleware by being constructed with a nil
+// UserHandlerFunc.
+func Chain(builders ...Builder) Builder {
+	merged := &builder{}
+
+	for _, b := range builders {
+		bb, ok := b.(*builder)
+		if !ok {
+			continue
+		}
+
+		if bb.userHandler != nil {
+			merged.userHandler = bb.userHandler
+		}
+
+		if bb.logger != nil {
+			merged.logger = bb.logger
+		}
+
+		if bb.recoverFunc != nil {
+			merged.recoverFunc = bb.recoverFunc
+		}
+
+		if bb.printStack {
+			merged.printStack = true
+		}
+
+		if bb.compression != nil {
+			merged.compression = bb.compression
+		}
+
+		if bb.sessionStore != nil {
+			merged.sessionStore = bb.sessionStore
+		}
+
+		merged.authorizers = append(merged.authorizers, bb.authorizers...)
+		merged.cookieCodecs = append(merged.cookieCodecs, bb.cookieCodecs...)
+	}
+
+	for idx := len(builders) - 1; idx >= 0; idx-- {
+		bb, ok := builders[idx].(*builder)
+		if !ok {
+			continue
+		}
 
+		merged.respMonitors = append(merged.respMonitors, bb.respMonitors...)
+		merged.postMetrics = append(merged.postMetrics, bb.postMetrics...)
+	}
+
+	return merged
+}
+
+// InterceptResponseWriterI is a plugin replacement for the ResponseWriter interface, scoped to
+// a single in-flight request. It has additional functionality for http handlers to add cookies
+// and headers to the response.
+type InterceptResponseWriterI interface {
 	// Methods that match the http.ResponseWriter interface
 	Header() http.Header
 	// Write buffers the response bytes. It does not write the response immediately.
@@ -41,13 +159,17 @@ type InterceptResponseWriterI interface {
 	// AddHeader can be called multiple times to add headers to the response
 	AddHeader(name, value string)
 
-	// WithPre allows adding pre-handler function to list of pre-handler functions.
-	// Pre-handlers are called before the user provided handler function is called.
-	WithPre(af AuthorizerFunc) InterceptResponseWriterI
+	// SetSignedCookie authenticates (and optionally encrypts) value via the configured
+	// CookieCodec and sets it as a response cookie.
+	SetSignedCookie(name string, value any, opts ...CookieOption) error
 
-	// WithPost allows adding post-handler function to list of post-handler functions.
-	// Post-handlers are called after the user provided handler function is called.
-	WithPost(PostResponseFunc) InterceptResponseWriterI
+	// GetSignedCookie reads, verifies, and decodes the named cookie from r into dst.
+	GetSignedCookie(r *http.Request, name string, dst any) error
+
+	// Session lazily loads (or creates) the named session from the request via the configured
+	// session.Store. Once a session has been loaded, it is saved into the response, via its
+	// Store, before the buffered response bytes are flushed.
+	Session(name string) (*session.Session, error)
 }
 
 // UserHandlerFunc matches closely with the handler function signature of http.HandleFunc.
@@ -66,44 +188,286 @@ type AuthorizerFunc func(w InterceptResponseWriterI, r *http.Request) (error, in
 // PostResponseFunc method can interrogate the request and response after the user handler has run.
 type PostResponseFunc func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte)
 
-type interceptResponseWriter struct {
-	rw           http.ResponseWriter
+// Metrics captures details about the in-flight response that would otherwise require a
+// PostResponseFunc to recompute by hand, such as walking respBytes to count bytes written.
+type Metrics struct {
+	// Code is the status code that will be sent, either via WriteHeader or the http.StatusOK
+	// default when WriteHeader was never called.
+	Code int
+	// Written is the total number of response bytes that will be sent on the wire: the
+	// compressed size when WithCompression negotiated an encoding for this response, or the
+	// buffered size otherwise.
+	Written int64
+	// Duration is how long ServeHTTP has been running, from entry up to this point.
+	Duration time.Duration
+	// WroteHeader reports whether the user handler called WriteHeader explicitly.
+	WroteHeader bool
+}
+
+// PostMetricsFunc is like PostResponseFunc but additionally receives the Metrics captured for
+// this response. Registered via WithPostMetrics; it coexists with plain PostResponseFunc.
+type PostMetricsFunc func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics)
+
+// CompressionOptions configures WithCompression.
+type CompressionOptions struct {
+	// MinBytes is the minimum buffered response size eligible for compression. Responses
+	// smaller than this are left untouched. Zero means always eligible.
+	MinBytes int
+	// Level is passed to the underlying compressor, eg. gzip.DefaultCompression.
+	Level int
+	// Encodings lists the content-codings this handler is willing to produce, in preference
+	// order, matched against the request's Accept-Encoding header. Defaults to
+	// []string{"gzip", "deflate"} when left empty. Encodings with no registered compressor
+	// (eg. "br" until a brotli encoder is wired in) are silently never negotiated.
+	Encodings []string
+	// ContentTypes restricts compression to these content-types (matched against the response's
+	// Content-Type header, ignoring any parameters). If empty, all content-types are eligible.
+	ContentTypes []string
+}
+
+// compressor produces a compressed copy of data at the given level.
+type compressor func(data []byte, level int) ([]byte, error)
+
+func gzipCompress(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func deflateCompress(data []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+
+	zw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// compressors maps a content-coding name to the function that produces it. "br" is
+// intentionally absent until a brotli implementation is added as a dependency.
+var compressors = map[string]compressor{
+	"gzip":    gzipCompress,
+	"deflate": deflateCompress,
+}
+
+// negotiateEncoding returns the first of encodings, in order, that both has a registered
+// compressor and is acceptable per the request's Accept-Encoding header. It returns "" when
+// nothing matches.
+func negotiateEncoding(acceptEncoding string, encodings []string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, qStr, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		if q := strings.TrimSpace(qStr); strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(strings.TrimPrefix(q, "q="), 64); err == nil && v == 0 {
+				continue
+			}
+		}
+
+		accepted[name] = true
+	}
+
+	for _, enc := range encodings {
+		if _, ok := compressors[enc]; !ok {
+			continue
+		}
+
+		if accepted[enc] || accepted["*"] {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+// RecoverFunc is invoked after a panic has been recovered from the authorizer, user-handler, or
+// post-handler phase of ServeHTTP. By the time onPanic runs, any bytes already buffered via
+// Write have been discarded, so onPanic is expected to write whatever response the client should
+// receive, typically via http.Error(w, ...). rec is the value passed to panic().
+type RecoverFunc func(w InterceptResponseWriterI, r *http.Request, rec any)
+
+// DefaultRecoverFunc is the RecoverFunc installed by WithRecover when called with a nil onPanic.
+// It writes a bare 500 Internal Server Error, with no details of rec, to the client.
+func DefaultRecoverFunc(w InterceptResponseWriterI, r *http.Request, rec any) {
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// builder holds the configuration shared by every request a Builder handles. It never mutates
+// after construction except via the With* calls a caller makes before mounting it, so a single
+// builder can back many concurrent requests; all request-scoped, mutable state lives on the
+// interceptResponseWriter ServeHTTP allocates per call.
+type builder struct {
 	userHandler  UserHandlerFunc
 	authorizers  []AuthorizerFunc
 	respMonitors []PostResponseFunc
-	respBytes    *[][]byte
+	postMetrics  []PostMetricsFunc
 	logger       alogger.LoggerI
+	recoverFunc  RecoverFunc
+	printStack   bool
+	compression  *CompressionOptions
+	cookieCodecs []*CookieCodec
+	sessionStore session.Store
 }
 
-// New returns the interface used for the handler with the http.HandleFunc registration call.
-func New(userHandler UserHandlerFunc, authorizer AuthorizerFunc, userRespMonitor PostResponseFunc, logger alogger.LoggerI) InterceptResponseWriterI {
-	respBytes := make([][]byte, 0)
+// New returns a Builder for a route: register it directly as an http.Handler, or via Mount.
+func New(userHandler UserHandlerFunc, authorizer AuthorizerFunc, userRespMonitor PostResponseFunc, logger alogger.LoggerI) Builder {
+	b := &builder{
+		userHandler: userHandler,
+		logger:      logger,
+	}
 
-	authFuncs := make([]AuthorizerFunc, 0)
 	if authorizer != nil {
-		authFuncs = append(authFuncs, authorizer)
+		b.authorizers = append(b.authorizers, authorizer)
 	}
 
-	respFuncs := make([]PostResponseFunc, 0)
 	if userRespMonitor != nil {
-		respFuncs = append(respFuncs, userRespMonitor)
+		b.respMonitors = append(b.respMonitors, userRespMonitor)
+	}
+
+	return b
+}
+
+func (b *builder) WithPre(af AuthorizerFunc) Builder {
+	if af == nil {
+		return b
+	}
+
+	b.authorizers = append(b.authorizers, af)
+
+	return b
+}
+
+func (b *builder) WithPost(pr PostResponseFunc) Builder {
+	if pr == nil {
+		return b
 	}
 
-	return &interceptResponseWriter{
-		userHandler:  userHandler,
-		authorizers:  authFuncs,
-		respMonitors: respFuncs,
-		respBytes:    &respBytes,
-		logger:       logger,
+	b.respMonitors = append(b.respMonitors, pr)
+
+	return b
+}
+
+func (b *builder) WithPostMetrics(pmf PostMetricsFunc) Builder {
+	if pmf == nil {
+		return b
 	}
+
+	b.postMetrics = append(b.postMetrics, pmf)
+
+	return b
+}
+
+func (b *builder) WithRecover(onPanic RecoverFunc) Builder {
+	if onPanic == nil {
+		onPanic = DefaultRecoverFunc
+	}
+
+	b.recoverFunc = onPanic
+
+	return b
+}
+
+func (b *builder) WithPrintStack(print bool) Builder {
+	b.printStack = print
+
+	return b
+}
+
+func (b *builder) WithCompression(opts CompressionOptions) Builder {
+	if len(opts.Encodings) == 0 {
+		opts.Encodings = []string{"gzip", "deflate"}
+	}
+
+	b.compression = &opts
+
+	return b
+}
+
+func (b *builder) WithCookieCodec(codecs ...*CookieCodec) Builder {
+	b.cookieCodecs = append(b.cookieCodecs, codecs...)
+
+	return b
+}
+
+func (b *builder) WithSessionStore(store session.Store) Builder {
+	b.sessionStore = store
+
+	return b
+}
+
+func (b *builder) Mount(mux *http.ServeMux, pattern string) {
+	mux.Handle(pattern, b)
+}
+
+// ServeHTTP allocates a fresh, request-scoped interceptResponseWriter and runs this builder's
+// authorizer, user-handler, and post-handler phases over it.
+func (b *builder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	respBytes := make([][]byte, 0)
+
+	i := &interceptResponseWriter{
+		builder:   b,
+		rw:        w,
+		req:       r,
+		respBytes: &respBytes,
+		start:     time.Now(),
+	}
+
+	i.run()
+}
+
+// interceptResponseWriter is the request-scoped InterceptResponseWriterI implementation
+// allocated by builder.ServeHTTP for each incoming request.
+type interceptResponseWriter struct {
+	builder     *builder
+	rw          http.ResponseWriter
+	req         *http.Request
+	respBytes   *[][]byte
+	start       time.Time
+	statusCode  int
+	wroteHeader bool
+	sessions    map[string]*session.Session
 }
 
 func (i *interceptResponseWriter) Header() http.Header {
 	return i.rw.Header()
 }
 
+// WriteHeader buffers the intended status code until flush time instead of writing it straight
+// through, so it can be recorded in Metrics.Code and so a recovered panic can still replace it.
 func (i *interceptResponseWriter) WriteHeader(statusCode int) {
-	i.rw.WriteHeader(statusCode)
+	i.statusCode = statusCode
+	i.wroteHeader = true
 }
 
 func (i *interceptResponseWriter) Write(b []byte) (int, error) {
@@ -120,56 +484,277 @@ func (i *interceptResponseWriter) AddHeader(name, value string) {
 	i.rw.Header().Add(name, value)
 }
 
-func (i *interceptResponseWriter) WithPre(af AuthorizerFunc) InterceptResponseWriterI {
-	if af == nil {
-		return i
+// Session lazily loads (or creates) the named session from the current request. Every session
+// loaded this way is saved, via saveSessions, before the buffered response bytes are flushed.
+func (i *interceptResponseWriter) Session(name string) (*session.Session, error) {
+	if i.builder.sessionStore == nil {
+		return nil, session.ErrNoStore
 	}
 
-	i.authorizers = append(i.authorizers, af)
+	if sess, ok := i.sessions[name]; ok {
+		return sess, nil
+	}
+
+	sess, err := i.builder.sessionStore.Get(i.req, name)
+	if sess == nil {
+		return nil, err
+	}
 
-	return i
+	if i.sessions == nil {
+		i.sessions = make(map[string]*session.Session)
+	}
+
+	i.sessions[name] = sess
+
+	return sess, err
 }
 
-func (i *interceptResponseWriter) WithPost(pr PostResponseFunc) InterceptResponseWriterI {
-	if pr == nil {
-		return i
+// saveSessions saves every session loaded via Session during this request, so modified session
+// values are written into the response cookies before the buffered bytes are flushed. It is
+// guarded by panic recovery when WithRecover has been configured, same as the other phases, so a
+// panicking Store.Save still falls through to the post-metrics/flush path.
+func (i *interceptResponseWriter) saveSessions(r *http.Request) {
+	if i.builder.recoverFunc != nil {
+		defer func() {
+			if rec := recover(); rec != nil {
+				i.handlePanic("post-handler", r, rec)
+			}
+		}()
+	}
+
+	for _, sess := range i.sessions {
+		if err := sess.Save(r, i); err != nil {
+			i.builder.logger.Errorf("interceptor:ServeHTTP: session %q save failed: error=%v\n", sess.Name, err)
+		}
+	}
+}
+
+// handlePanic logs a panic recovered from the given phase, discards whatever bytes have been
+// buffered for the in-flight response so far, and hands off to the builder's RecoverFunc to
+// write the replacement response. Callers must only invoke this when a RecoverFunc is
+// configured.
+func (i *interceptResponseWriter) handlePanic(phase string, r *http.Request, rec any) {
+	if i.builder.printStack {
+		i.builder.logger.Errorf("interceptor:ServeHTTP: recovered panic in %s: %v\n%s\n", phase, rec, debug.Stack())
+	} else {
+		i.builder.logger.Errorf("interceptor:ServeHTTP: recovered panic in %s: %v\n", phase, rec)
+	}
+
+	(*i.respBytes) = (*i.respBytes)[:0]
+
+	i.builder.recoverFunc(i, r, rec)
+}
+
+// runAuthorizer invokes af, guarding it with panic recovery when WithRecover has been configured.
+// denied reports a genuine auth denial: af's response has already been written straight through
+// to i.rw via http.Error, so ServeHTTP must stop processing immediately without touching the
+// buffered response. panicked reports a recovered panic: handlePanic has buffered the
+// replacement response via i, same as the user-handler and post-handler phases, so ServeHTTP
+// should skip the remaining phases up to and including the user handler but still fall through
+// to the respMonitors/metrics/compress/flush path, so post-handlers keep seeing every request.
+func (i *interceptResponseWriter) runAuthorizer(af AuthorizerFunc, r *http.Request) (denied, panicked bool) {
+	if i.builder.recoverFunc != nil {
+		defer func() {
+			if rec := recover(); rec != nil {
+				i.handlePanic("authorizer", r, rec)
+				panicked = true
+			}
+		}()
 	}
 
-	i.respMonitors = append(i.respMonitors, pr)
+	// ex: error, http.StatusUnauthorized, "Not authorized"
+	err, statusCode, msg := af(i, r)
+	if err != nil {
+		if msg == "" {
+			msg = err.Error()
+		}
+
+		i.builder.logger.Errorf("interceptor:ServeHTTP: authorizer failed: error=%v\n", err)
+		http.Error(i.rw, msg, statusCode)
+		denied = true
+	}
 
-	return i
+	return denied, panicked
 }
 
-// HandleFunc is the handler you pass to http.HandleFunc
-// Direct the logging via log.SetOutput(logger)
-func (i *interceptResponseWriter) HandleFunc(w http.ResponseWriter, r *http.Request) {
-	i.rw = w
+// runUserHandler invokes the user's handler, guarding it with panic recovery when WithRecover has
+// been configured.
+func (i *interceptResponseWriter) runUserHandler(r *http.Request) {
+	if i.builder.recoverFunc != nil {
+		defer func() {
+			if rec := recover(); rec != nil {
+				i.handlePanic("handler", r, rec)
+			}
+		}()
+	}
 
-	for _, af := range i.authorizers {
-		// ex: error, http.StatusUnauthorized, "Not authorized"
-		err, statusCode, msg := af(i, r)
-		if err != nil {
-			if msg == "" {
-				msg = err.Error()
+	i.builder.userHandler(i, r)
+}
+
+// runPostHandler invokes rmf, guarding it with panic recovery when WithRecover has been configured.
+// Post-handlers still run over whatever replacement response a recovered panic produced, so metrics
+// pipelines see every request.
+func (i *interceptResponseWriter) runPostHandler(rmf PostResponseFunc, r *http.Request) {
+	if i.builder.recoverFunc != nil {
+		defer func() {
+			if rec := recover(); rec != nil {
+				i.handlePanic("post-handler", r, rec)
+			}
+		}()
+	}
+
+	rmf(i, r, i.respBytes)
+}
+
+// runPostMetrics invokes pmf with the given Metrics, guarding it with panic recovery when
+// WithRecover has been configured.
+func (i *interceptResponseWriter) runPostMetrics(pmf PostMetricsFunc, r *http.Request, m *Metrics) {
+	if i.builder.recoverFunc != nil {
+		defer func() {
+			if rec := recover(); rec != nil {
+				i.handlePanic("post-handler", r, rec)
 			}
+		}()
+	}
+
+	pmf(i, r, i.respBytes, m)
+}
 
-			i.logger.Errorf("interceptor:HandleFunc: authorizer failed: error=%v\n", err)
-			http.Error(w, msg, statusCode)
+// metrics computes the Metrics for the response as buffered so far.
+func (i *interceptResponseWriter) metrics() *Metrics {
+	written := int64(0)
+	for _, chunk := range *i.respBytes {
+		written += int64(len(chunk))
+	}
+
+	code := i.statusCode
+	if !i.wroteHeader {
+		code = http.StatusOK
+	}
+
+	return &Metrics{
+		Code:        code,
+		Written:     written,
+		Duration:    time.Since(i.start),
+		WroteHeader: i.wroteHeader,
+	}
+}
+
+// compress negotiates a Content-Encoding against r's Accept-Encoding header and, if one is
+// found and the buffered response qualifies, replaces respBytes with a single compressed
+// buffer and sets the Content-Encoding/Vary/Content-Length headers accordingly.
+func (i *interceptResponseWriter) compress(r *http.Request) {
+	if i.builder.compression == nil {
+		return
+	}
+
+	opts := i.builder.compression
+	hdr := i.rw.Header()
+
+	if hdr.Get("Content-Encoding") != "" {
+		return
+	}
+
+	if cts := opts.ContentTypes; len(cts) > 0 {
+		ct, _, _ := strings.Cut(hdr.Get("Content-Type"), ";")
+		ct = strings.TrimSpace(ct)
+
+		matched := false
+		for _, allowed := range cts {
+			if strings.EqualFold(ct, allowed) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
 			return
 		}
+	}
 
+	total := 0
+	for _, chunk := range *i.respBytes {
+		total += len(chunk)
 	}
 
-	i.userHandler(i, r)
+	if total < opts.MinBytes {
+		return
+	}
 
-	for _, rmf := range i.respMonitors {
-		rmf(i, r, i.respBytes)
+	enc := negotiateEncoding(r.Header.Get("Accept-Encoding"), opts.Encodings)
+	if enc == "" {
+		return
 	}
 
+	body := make([]byte, 0, total)
+	for _, chunk := range *i.respBytes {
+		body = append(body, chunk...)
+	}
+
+	compressed, err := compressors[enc](body, opts.Level)
+	if err != nil {
+		i.builder.logger.Errorf("interceptor:ServeHTTP: compression with %s failed: error=%v\n", enc, err)
+		return
+	}
+
+	if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		hdr.Set("ETag", "W/"+etag)
+	}
+
+	hdr.Set("Content-Encoding", enc)
+	hdr.Add("Vary", "Accept-Encoding")
+	hdr.Set("Content-Length", strconv.Itoa(len(compressed)))
+
+	(*i.respBytes) = [][]byte{compressed}
+}
+
+// run executes the authorizer, user-handler, and post-handler phases for this request, then
+// flushes the buffered response. A recovered panic from any phase, including an authorizer,
+// still falls through to the remaining post-handler/metrics/compress/flush steps, so metrics
+// pipelines see every request. Direct the logging via log.SetOutput(logger).
+func (i *interceptResponseWriter) run() {
+	r := i.req
+
+	panicked := false
+	for _, af := range i.builder.authorizers {
+		denied, p := i.runAuthorizer(af, r)
+		if p {
+			panicked = true
+			break
+		}
+
+		if denied {
+			return
+		}
+	}
+
+	if !panicked {
+		i.runUserHandler(r)
+	}
+
+	for _, rmf := range i.builder.respMonitors {
+		i.runPostHandler(rmf, r)
+	}
+
+	if i.sessions != nil {
+		i.saveSessions(r)
+	}
+
+	i.compress(r)
+
+	m := i.metrics()
+	for _, pmf := range i.builder.postMetrics {
+		i.runPostMetrics(pmf, r, m)
+	}
+
+	// Recomputed rather than reusing m.Code, since a recovered panic in a post-metrics handler
+	// may have replaced the status code after m was taken.
+	i.rw.WriteHeader(i.metrics().Code)
+
 	for _, chunk := range *i.respBytes {
-		n, err := w.Write(chunk)
+		n, err := i.rw.Write(chunk)
 		if err != nil {
-			i.logger.Errorf("interceptor:HandleFunc: n=%d failed: error=%v\n", n, err)
+			i.builder.logger.Errorf("interceptor:ServeHTTP: n=%d failed: error=%v\n", n, err)
 			return
 		}
 	}
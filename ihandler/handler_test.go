@@ -15,6 +15,7 @@
 package ihandler
 
 import (
+	"compress/gzip"
 	"io"
 	"log"
 	"net/http"
@@ -24,6 +25,7 @@ import (
 	"time"
 
 	"github.com/bluelamar/abstract-logger-go/alogger"
+	"github.com/bluelamar/http-interceptor-go/session"
 )
 
 var (
@@ -99,26 +101,17 @@ func updateMyResource(w InterceptResponseWriterI, r *http.Request) {
 	w.Write([]byte(txt))
 }
 
-type testHandler struct {
-	irw InterceptResponseWriterI
-}
-
-func (h testHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.irw.HandleFunc(w, r)
+func panicyHandler(w InterceptResponseWriterI, r *http.Request) {
+	w.Write([]byte("partial response before panic"))
+	panic("something went wrong in panicyHandler")
 }
 
 func TestMissingCookie(t *testing.T) {
 
 	l := alogger.New(nil, true)
 	ihu := New(updateMyResource, myRealAuthorizer, nil, l)
-	// http.HandleFunc("/update", ihu.HandleFunc)
-	// http.HandleFunc("/update", updateMyResource)
-
-	th := &testHandler{
-		irw: ihu,
-	}
 
-	ts := httptest.NewServer(th)
+	ts := httptest.NewServer(ihu)
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL)
@@ -145,16 +138,10 @@ func TestMissingCookie(t *testing.T) {
 
 func TestReturnedCookie(t *testing.T) {
 
-	// http.HandleFunc("/login", loginPage)
-	// http.HandleFunc("/login", ihd.HandleFunc)
 	l := alogger.New(nil, true)
 	ihd := New(loginPage, myDummyAuthorizer, myRespChecker, l)
 
-	th := &testHandler{
-		irw: ihd,
-	}
-
-	ts := httptest.NewServer(th)
+	ts := httptest.NewServer(ihd)
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL)
@@ -185,3 +172,403 @@ func TestReturnedCookie(t *testing.T) {
 		t.Fatalf(`Expected cookie(%s) for login response but received msg(%s)`, cookieValPrefix, cookieVal)
 	}
 }
+
+func TestRecoverFromPanic(t *testing.T) {
+
+	l := alogger.New(nil, true)
+	postHandlerCalled := false
+	postHandler := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte) {
+		postHandlerCalled = true
+	}
+
+	ihp := New(panicyHandler, myDummyAuthorizer, postHandler, l).WithRecover(nil)
+
+	ts := httptest.NewServer(ihp)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf(`Expected status code(%d) after recovered panic: received status code: %d`, http.StatusInternalServerError, res.StatusCode)
+	}
+
+	respMsg, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	respMsgStr := string(respMsg)
+
+	if strings.Contains(respMsgStr, "partial response before panic") {
+		t.Fatalf(`Expected buffered bytes from before the panic to be discarded but received msg(%s)`, respMsgStr)
+	}
+
+	if !postHandlerCalled {
+		t.Fatalf(`Expected post-handler to still run over the replacement response after a recovered panic`)
+	}
+}
+
+func TestRecoverFromPanicInAuthorizer(t *testing.T) {
+
+	l := alogger.New(nil, true)
+	postHandlerCalled := false
+	postHandler := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte) {
+		postHandlerCalled = true
+	}
+	panicyAuthorizer := func(w InterceptResponseWriterI, r *http.Request) (error, int, string) {
+		panic("something went wrong in panicyAuthorizer")
+	}
+
+	iha := New(loginPage, panicyAuthorizer, postHandler, l).WithRecover(nil)
+
+	ts := httptest.NewServer(iha)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf(`Expected status code(%d) after recovered authorizer panic: received status code: %d`, http.StatusInternalServerError, res.StatusCode)
+	}
+
+	respMsg, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(respMsg), loginResp) {
+		t.Fatalf(`Expected the user handler to be skipped after an authorizer panic but received msg(%s)`, string(respMsg))
+	}
+
+	if !postHandlerCalled {
+		t.Fatalf(`Expected post-handler to still run over the replacement response after a recovered authorizer panic`)
+	}
+}
+
+func TestPostMetrics(t *testing.T) {
+
+	l := alogger.New(nil, true)
+	var gotMetrics Metrics
+	metricsCollector := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics) {
+		gotMetrics = *m
+	}
+
+	ihm := New(loginPage, myDummyAuthorizer, nil, l).WithPostMetrics(metricsCollector)
+
+	ts := httptest.NewServer(ihm)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if gotMetrics.Code != http.StatusOK {
+		t.Fatalf(`Expected Metrics.Code(%d) but got(%d)`, http.StatusOK, gotMetrics.Code)
+	}
+
+	wantWritten := int64(len(loginResp))
+	if gotMetrics.Written != wantWritten {
+		t.Fatalf(`Expected Metrics.Written(%d) but got(%d)`, wantWritten, gotMetrics.Written)
+	}
+}
+
+func TestCompression(t *testing.T) {
+
+	l := alogger.New(nil, true)
+	ihc := New(loginPage, myDummyAuthorizer, nil, l).WithCompression(CompressionOptions{})
+
+	ts := httptest.NewServer(ihc)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf(`Expected Content-Encoding(gzip) but got(%s)`, res.Header.Get("Content-Encoding"))
+	}
+
+	zr, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	respMsg, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(string(respMsg), loginResp) {
+		t.Fatalf(`Expected msg(%s) for compressed login response but received msg(%s)`, loginResp, string(respMsg))
+	}
+}
+
+func TestCompressionReflectedInPostMetrics(t *testing.T) {
+
+	body := strings.Repeat("a", 2400)
+	textHandler := func(w InterceptResponseWriterI, r *http.Request) {
+		w.Write([]byte(body))
+	}
+
+	l := alogger.New(nil, true)
+	var gotMetrics Metrics
+	metricsCollector := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics) {
+		gotMetrics = *m
+	}
+
+	iht := New(textHandler, myDummyAuthorizer, nil, l).
+		WithCompression(CompressionOptions{}).
+		WithPostMetrics(metricsCollector)
+
+	ts := httptest.NewServer(iht)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	wireBytes, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotMetrics.Written != int64(len(wireBytes)) {
+		t.Fatalf(`Expected Metrics.Written(%d) to reflect the size actually sent on the wire but got(%d)`, len(wireBytes), gotMetrics.Written)
+	}
+}
+
+func TestSignedCookieRoundTrip(t *testing.T) {
+
+	codec := NewCookieCodec([]byte("0123456789abcdef0123456789abcdef"), nil, time.Hour)
+
+	setSignedCookieHandler := func(w InterceptResponseWriterI, r *http.Request) {
+		if err := w.SetSignedCookie("session", "user-42"); err != nil {
+			t.Fatalf(`SetSignedCookie failed: %v`, err)
+		}
+
+		w.Write([]byte("ok"))
+	}
+
+	l := alogger.New(nil, true)
+	ihs := New(setSignedCookieHandler, myDummyAuthorizer, nil, l).WithCookieCodec(codec)
+
+	ts := httptest.NewServer(ihs)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	var sessionCookie *http.Cookie
+	for _, ck := range res.Cookies() {
+		if ck.Name == "session" {
+			sessionCookie = ck
+		}
+	}
+	if sessionCookie == nil {
+		t.Fatal(`Expected a "session" cookie in the response`)
+	}
+
+	var got string
+	if err := codec.Decode("session", sessionCookie.Value, &got); err != nil {
+		t.Fatalf(`Decode failed: %v`, err)
+	}
+
+	if got != "user-42" {
+		t.Fatalf(`Expected decoded cookie value("user-42") but got(%q)`, got)
+	}
+}
+
+func TestSessionSavedOnFlush(t *testing.T) {
+
+	store := session.NewMemoryStore(session.Options{Path: "/"})
+
+	setVisitHandler := func(w InterceptResponseWriterI, r *http.Request) {
+		sess, err := w.Session("visit")
+		if err != nil && sess == nil {
+			t.Fatalf(`Session failed: %v`, err)
+		}
+
+		sess.Values["count"] = 1
+		w.Write([]byte("ok"))
+	}
+
+	l := alogger.New(nil, true)
+	ihs := New(setVisitHandler, myDummyAuthorizer, nil, l).WithSessionStore(store)
+
+	ts := httptest.NewServer(ihs)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if len(res.Cookies()) == 0 {
+		t.Fatal(`Expected Session to have saved a "visit" cookie into the response`)
+	}
+}
+
+// panicyStore is a session.Store whose Save always panics, for exercising the recovery path
+// around saveSessions.
+type panicyStore struct{}
+
+func (panicyStore) Get(r *http.Request, name string) (*session.Session, error) {
+	return session.NewSession(panicyStore{}, name), nil
+}
+
+func (panicyStore) New(r *http.Request, name string) (*session.Session, error) {
+	return session.NewSession(panicyStore{}, name), nil
+}
+
+func (panicyStore) Save(r *http.Request, w session.CookieSetter, s *session.Session) error {
+	panic("something went wrong in panicyStore.Save")
+}
+
+func TestRecoverFromPanicInSessionSave(t *testing.T) {
+
+	var gotMetrics Metrics
+	metricsCollector := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics) {
+		gotMetrics = *m
+	}
+
+	setVisitHandler := func(w InterceptResponseWriterI, r *http.Request) {
+		sess, err := w.Session("visit")
+		if err != nil && sess == nil {
+			t.Fatalf(`Session failed: %v`, err)
+		}
+
+		sess.Values["count"] = 1
+		w.Write([]byte("ok"))
+	}
+
+	l := alogger.New(nil, true)
+	ihs := New(setVisitHandler, myDummyAuthorizer, nil, l).
+		WithSessionStore(panicyStore{}).
+		WithPostMetrics(metricsCollector).
+		WithRecover(nil)
+
+	ts := httptest.NewServer(ihs)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf(`Expected status code(%d) after recovered session-save panic: received status code: %d`, http.StatusInternalServerError, res.StatusCode)
+	}
+
+	if gotMetrics.Code != http.StatusInternalServerError {
+		t.Fatalf(`Expected post-metrics to still run over the replacement response after a recovered session-save panic, got Metrics.Code(%d)`, gotMetrics.Code)
+	}
+}
+
+func TestChainMergesAuthorizersAndPostHandlers(t *testing.T) {
+
+	var order []string
+
+	authA := func(w InterceptResponseWriterI, r *http.Request) (error, int, string) {
+		order = append(order, "authA")
+		return nil, 0, ""
+	}
+	authB := func(w InterceptResponseWriterI, r *http.Request) (error, int, string) {
+		order = append(order, "authB")
+		return nil, 0, ""
+	}
+	postA := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte) {
+		order = append(order, "postA")
+	}
+	postB := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte) {
+		order = append(order, "postB")
+	}
+	pmA := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics) {
+		order = append(order, "pmA")
+	}
+	pmB := func(w InterceptResponseWriterI, r *http.Request, respBytes *[][]byte, m *Metrics) {
+		order = append(order, "pmB")
+	}
+
+	l := alogger.New(nil, true)
+	a := New(nil, authA, postA, l).WithPostMetrics(pmA)
+	b := New(loginPage, authB, postB, l).WithPostMetrics(pmB)
+
+	ih := Chain(a, b)
+
+	ts := httptest.NewServer(ih)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.ReadAll(res.Body)
+	res.Body.Close()
+
+	wantOrder := []string{"authA", "authB", "postB", "postA", "pmB", "pmA"}
+	if len(order) != len(wantOrder) {
+		t.Fatalf(`Expected call order(%v) but got(%v)`, wantOrder, order)
+	}
+	for i, name := range wantOrder {
+		if order[i] != name {
+			t.Fatalf(`Expected call order(%v) but got(%v)`, wantOrder, order)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+
+	l := alogger.New(nil, true)
+	ih := New(loginPage, myDummyAuthorizer, nil, l)
+
+	mux := http.NewServeMux()
+	ih.Mount(mux, "/login")
+
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf(`Expected status code(%d) but got(%d)`, http.StatusOK, res.StatusCode)
+	}
+}
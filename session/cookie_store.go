@@ -0,0 +1,88 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import "net/http"
+
+// CookieCodec is the subset of ihandler.CookieCodec's behavior CookieStore needs to
+// authenticate (and optionally encrypt) session values. Any *ihandler.CookieCodec satisfies
+// this already; it is declared here, rather than imported, so this package has no dependency
+// on ihandler.
+type CookieCodec interface {
+	Encode(name string, value any) (string, error)
+	Decode(name, value string, dst any) error
+}
+
+// CookieStore keeps session values in the cookie itself, authenticated (and optionally
+// encrypted) via Codec.
+type CookieStore struct {
+	Codec   CookieCodec
+	Options Options
+}
+
+// NewCookieStore creates a CookieStore that authenticates session values via codec and stamps
+// cookies with opts.
+func NewCookieStore(codec CookieCodec, opts Options) *CookieStore {
+	return &CookieStore{
+		Codec:   codec,
+		Options: opts,
+	}
+}
+
+func (s *CookieStore) Get(r *http.Request, name string) (*Session, error) {
+	sess := NewSession(s, name)
+	sess.Options = s.Options
+
+	ck, err := r.Cookie(name)
+	if err != nil {
+		return sess, err
+	}
+
+	if err := s.Codec.Decode(name, ck.Value, &sess.Values); err != nil {
+		sess.Values = make(map[string]any)
+		return sess, err
+	}
+
+	sess.isNew = false
+
+	return sess, nil
+}
+
+func (s *CookieStore) New(r *http.Request, name string) (*Session, error) {
+	sess := NewSession(s, name)
+	sess.Options = s.Options
+
+	return sess, nil
+}
+
+func (s *CookieStore) Save(r *http.Request, w CookieSetter, sess *Session) error {
+	encoded, err := s.Codec.Encode(sess.Name, sess.Values)
+	if err != nil {
+		return err
+	}
+
+	w.SetCookie(&http.Cookie{
+		Name:     sess.Name,
+		Value:    encoded,
+		Path:     sess.Options.Path,
+		Domain:   sess.Options.Domain,
+		MaxAge:   sess.Options.MaxAge,
+		Secure:   sess.Options.Secure,
+		HttpOnly: sess.Options.HttpOnly,
+		SameSite: sess.Options.SameSite,
+	})
+
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// MemoryStore keeps session values server-side, in memory, behind a random session id cookie.
+// It is intended for development and single-process deployments; values do not survive a
+// restart and are not shared across processes.
+type MemoryStore struct {
+	Options Options
+
+	mu       sync.Mutex
+	sessions map[string]map[string]any
+}
+
+// NewMemoryStore creates an empty MemoryStore that stamps cookies with opts.
+func NewMemoryStore(opts Options) *MemoryStore {
+	return &MemoryStore{
+		Options:  opts,
+		sessions: make(map[string]map[string]any),
+	}
+}
+
+func (s *MemoryStore) Get(r *http.Request, name string) (*Session, error) {
+	sess := NewSession(s, name)
+	sess.Options = s.Options
+
+	ck, err := r.Cookie(name)
+	if err != nil {
+		return sess, err
+	}
+
+	s.mu.Lock()
+	values, ok := s.sessions[ck.Value]
+	if ok {
+		values = copyValues(values)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return sess, fmt.Errorf("session: no session found for id %q", ck.Value)
+	}
+
+	sess.id = ck.Value
+	sess.isNew = false
+	sess.Values = values
+
+	return sess, nil
+}
+
+func (s *MemoryStore) New(r *http.Request, name string) (*Session, error) {
+	sess := NewSession(s, name)
+	sess.Options = s.Options
+
+	return sess, nil
+}
+
+func (s *MemoryStore) Save(r *http.Request, w CookieSetter, sess *Session) error {
+	if sess.id == "" {
+		id, err := newSessionID()
+		if err != nil {
+			return err
+		}
+
+		sess.id = id
+	}
+
+	s.mu.Lock()
+	s.sessions[sess.id] = copyValues(sess.Values)
+	s.mu.Unlock()
+
+	w.SetCookie(&http.Cookie{
+		Name:     sess.Name,
+		Value:    sess.id,
+		Path:     sess.Options.Path,
+		Domain:   sess.Options.Domain,
+		MaxAge:   sess.Options.MaxAge,
+		Secure:   sess.Options.Secure,
+		HttpOnly: sess.Options.HttpOnly,
+		SameSite: sess.Options.SameSite,
+	})
+
+	return nil
+}
+
+// copyValues returns a shallow copy of src, so the map backing a Session.Values is never the
+// same map instance stored in (or previously read from) MemoryStore.sessions. That keeps
+// concurrent requests sharing a session id from racing on each other's map writes.
+func copyValues(src map[string]any) map[string]any {
+	dst := make(map[string]any, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+
+	return dst
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package session provides a pluggable, cookie-backed session abstraction modelled on
+// gorilla/sessions, for use by ihandler.InterceptResponseWriterI.Session.
+package session
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrNoStore is returned by ihandler.InterceptResponseWriterI.Session when no Store has been
+// configured via WithSessionStore.
+var ErrNoStore = errors.New("session: no Store configured, call WithSessionStore")
+
+// Options mirror the cookie attributes a Store uses to track a Session.
+type Options struct {
+	Path     string
+	Domain   string
+	MaxAge   int
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+}
+
+// CookieSetter is satisfied by anything able to add a Set-Cookie header to the in-flight
+// response, such as ihandler.InterceptResponseWriterI.
+type CookieSetter interface {
+	SetCookie(cookie *http.Cookie)
+}
+
+// Store is implemented by the backing storage for sessions: CookieStore keeps the values in the
+// cookie itself, MemoryStore keeps them server-side behind a session id cookie.
+type Store interface {
+	// Get returns the named session for r, creating a new, empty one if it doesn't yet exist
+	// or fails to decode. Implementations return a usable *Session alongside a non-nil error
+	// in that case, matching gorilla/sessions, so callers can choose whether to treat it as
+	// fatal.
+	Get(r *http.Request, name string) (*Session, error)
+
+	// New always returns a new, empty session, ignoring any existing cookie.
+	New(r *http.Request, name string) (*Session, error)
+
+	// Save persists s and writes whatever cookie is needed to find it again via w.
+	Save(r *http.Request, w CookieSetter, s *Session) error
+}
+
+// Session holds arbitrary per-client values alongside the cookie Options used when it is saved.
+type Session struct {
+	Name    string
+	Values  map[string]any
+	Options Options
+	Store   Store
+
+	id    string
+	isNew bool
+}
+
+// NewSession creates an empty Session backed by store.
+func NewSession(store Store, name string) *Session {
+	return &Session{
+		Name:   name,
+		Values: make(map[string]any),
+		Store:  store,
+		isNew:  true,
+	}
+}
+
+// IsNew reports whether this Session was just created rather than loaded from an existing
+// cookie.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Save persists the session via its Store, writing the response cookie through w.
+func (s *Session) Save(r *http.Request, w CookieSetter) error {
+	return s.Store.Save(r, w, s)
+}
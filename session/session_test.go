@@ -0,0 +1,166 @@
+// Copyright 2023, Initialize All Once Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// You may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeCodec is a trivial CookieCodec for tests that does not need real authentication.
+type fakeCodec struct{}
+
+func (fakeCodec) Encode(name string, value any) (string, error) {
+	values, _ := value.(map[string]any)
+	v, _ := values["user"].(string)
+
+	return v, nil
+}
+
+func (fakeCodec) Decode(name, value string, dst any) error {
+	values, ok := dst.(*map[string]any)
+	if !ok {
+		return nil
+	}
+
+	*values = map[string]any{"user": value}
+
+	return nil
+}
+
+type testCookieSetter struct {
+	w http.ResponseWriter
+}
+
+func (t testCookieSetter) SetCookie(cookie *http.Cookie) {
+	http.SetCookie(t.w, cookie)
+}
+
+func TestCookieStoreRoundTrip(t *testing.T) {
+
+	store := NewCookieStore(fakeCodec{}, Options{Path: "/"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	sess, err := store.New(r, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !sess.IsNew() {
+		t.Fatal(`Expected a freshly created session to report IsNew() == true`)
+	}
+
+	sess.Values["user"] = "alice"
+
+	w := httptest.NewRecorder()
+	if err := sess.Save(r, testCookieSetter{w: w}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := w.Result()
+
+	req2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, ck := range res.Cookies() {
+		req2.AddCookie(ck)
+	}
+
+	got, err := store.Get(req2, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Values["user"] != "alice" {
+		t.Fatalf(`Expected session value("alice") but got(%v)`, got.Values["user"])
+	}
+}
+
+func TestMemoryStoreRoundTrip(t *testing.T) {
+
+	store := NewMemoryStore(Options{Path: "/"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	sess, err := store.New(r, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess.Values["user"] = "bob"
+
+	w := httptest.NewRecorder()
+	if err := sess.Save(r, testCookieSetter{w: w}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := w.Result()
+
+	req2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, ck := range res.Cookies() {
+		req2.AddCookie(ck)
+	}
+
+	got, err := store.Get(req2, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Values["user"] != "bob" {
+		t.Fatalf(`Expected session value("bob") but got(%v)`, got.Values["user"])
+	}
+}
+
+func TestMemoryStoreGetDoesNotAliasStoredValues(t *testing.T) {
+
+	store := NewMemoryStore(Options{Path: "/"})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	sess, err := store.New(r, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess.Values["user"] = "bob"
+
+	w := httptest.NewRecorder()
+	if err := sess.Save(r, testCookieSetter{w: w}); err != nil {
+		t.Fatal(err)
+	}
+
+	res := w.Result()
+
+	req2 := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, ck := range res.Cookies() {
+		req2.AddCookie(ck)
+	}
+
+	gotA, err := store.Get(req2, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotB, err := store.Get(req2, "session")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gotA.Values["user"] = "mutated"
+
+	if gotB.Values["user"] == "mutated" {
+		t.Fatal(`Expected two Get calls for the same session id to return independent maps, not aliases of the same stored map`)
+	}
+}